@@ -0,0 +1,229 @@
+// Copyright 2015 by caixw, All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// hashPattern 用于校验hash参数是否为合法的sha256十六进制串，避免其被当作路径的
+// 一部分参与拼接，从而越过u.dir写入任意位置。
+var hashPattern = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// chunkTmpDir 返回hash对应的分块临时存放目录。
+func (u *Upload) chunkTmpDir(hash string) string {
+	return filepath.Join(u.dir, ".tmp", hash)
+}
+
+// chunkPath 返回hash的第index块分块文件的路径。
+func (u *Upload) chunkPath(hash string, index int) string {
+	return filepath.Join(u.chunkTmpDir(hash), fmt.Sprintf("%s-%d", hash, index))
+}
+
+// chunkDonePath 返回hash对应的完成标记文件的路径，其内容为最终文件相对于u.dir的路径。
+func (u *Upload) chunkDonePath(hash string) string {
+	return filepath.Join(u.dir, ".tmp", hash+".done")
+}
+
+// chunkMergedPath 返回hash对应的、用于合并分块的本地临时文件路径。合并、校验
+// 都在本地完成，只有通过校验之后的内容才会经u.storage写入最终的存储位置。
+func (u *Upload) chunkMergedPath(hash string) string {
+	return filepath.Join(u.chunkTmpDir(hash), hash+".merged")
+}
+
+// DoChunk 处理一次分块上传的请求，用于支持浏览器端的大文件分片上传。
+//
+// field 为上传的分块内容在表单中的字段名；请求还需要携带以下表单字段：
+// hash 整个文件的sha256值；index 当前分块的序号，从0开始；total 分块的总数量。
+//
+// 当 done 为 true 时，表示所有分块都已经上传完成，path 为合并之后的文件相对于
+// u.dir的路径；done为false时，path无意义。
+func (u *Upload) DoChunk(field string, w http.ResponseWriter, r *http.Request) (done bool, path string, err error) {
+	if err = r.ParseMultipartForm(32 << 20); err != nil {
+		return false, "", err
+	}
+
+	hash := r.FormValue("hash")
+	if !hashPattern.MatchString(hash) {
+		return false, "", errors.New("hash参数不是合法的sha256值")
+	}
+
+	ctx := context.Background()
+
+	// 若该hash对应的文件已经上传完成，直接返回，不再重复接收。
+	if p, ok := u.chunkCompleted(ctx, hash); ok {
+		return true, p, nil
+	}
+
+	index, err := strconv.Atoi(r.FormValue("index"))
+	if err != nil {
+		return false, "", err
+	}
+
+	total, err := strconv.Atoi(r.FormValue("total"))
+	if err != nil {
+		return false, "", err
+	}
+
+	file, head, err := r.FormFile(field)
+	if err != nil {
+		return false, "", err
+	}
+	defer file.Close()
+
+	ext := filepath.Ext(head.Filename)
+	_, p, ok := u.classify(ext)
+	if !ok {
+		return false, "", ErrNotAllowExt
+	}
+
+	if err = os.MkdirAll(u.chunkTmpDir(hash), defaultMode); err != nil {
+		return false, "", err
+	}
+
+	// 在写入当前分块之前先累加已收到的字节数，超出该类型允许的最大值时提前
+	// 拒绝，避免恶意客户端借助分块上传绕过单文件大小限制。
+	if size, err := u.chunkSize(hash); err != nil {
+		return false, "", err
+	} else if size+head.Size > p.MaxSize {
+		return false, "", ErrNotAllowSize
+	}
+
+	chunk, err := os.OpenFile(u.chunkPath(hash, index), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, defaultFileMode)
+	if err != nil {
+		return false, "", err
+	}
+	_, err = io.Copy(chunk, file)
+	chunk.Close()
+	if err != nil {
+		return false, "", err
+	}
+
+	// 还未接收完所有分块，等待下一个分块请求。
+	if index+1 < total {
+		return false, "", nil
+	}
+
+	return u.mergeChunks(ctx, hash, total, ext)
+}
+
+// chunkSize 统计hash对应的临时目录中已经写入的分块的总字节数，
+// 用于在接收新分块之前提前判断是否会超出该类型允许的最大大小。
+func (u *Upload) chunkSize(hash string) (int64, error) {
+	entries, err := ioutil.ReadDir(u.chunkTmpDir(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var size int64
+	for _, e := range entries {
+		size += e.Size()
+	}
+	return size, nil
+}
+
+// chunkCompleted 判断hash对应的文件是否已经上传完成，若是则返回其相对路径。
+func (u *Upload) chunkCompleted(ctx context.Context, hash string) (string, bool) {
+	data, err := ioutil.ReadFile(u.chunkDonePath(hash))
+	if err != nil {
+		return "", false
+	}
+
+	path := string(data)
+	ok, err := u.storage.Exists(ctx, path)
+	if err != nil || !ok {
+		return "", false
+	}
+	return path, true
+}
+
+// mergeChunks 按顺序将hash对应的所有分块在本地合并、校验其sha256值，
+// 通过后再经u.storage写入最终的存储位置，使DoChunk与Do共用同一套存储后端。
+func (u *Upload) mergeChunks(ctx context.Context, hash string, total int, ext string) (done bool, path string, err error) {
+	mergedPath := u.chunkMergedPath(hash)
+	tmp, err := os.OpenFile(mergedPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, defaultFileMode)
+	if err != nil {
+		return false, "", err
+	}
+
+	h := sha256.New()
+	for i := 0; i < total; i++ {
+		if err = u.appendChunk(tmp, h, u.chunkPath(hash, i)); err != nil {
+			tmp.Close()
+			os.RemoveAll(u.chunkTmpDir(hash))
+			return false, "", err
+		}
+	}
+	tmp.Close()
+
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != hash {
+		os.RemoveAll(u.chunkTmpDir(hash))
+		return false, "", fmt.Errorf("文件内容与声明的hash值%s不匹配", hash)
+	}
+
+	merged, err := os.Open(mergedPath)
+	if err != nil {
+		os.RemoveAll(u.chunkTmpDir(hash))
+		return false, "", err
+	}
+	defer merged.Close()
+
+	stat, err := merged.Stat()
+	if err != nil {
+		os.RemoveAll(u.chunkTmpDir(hash))
+		return false, "", err
+	}
+
+	// 分块逐一写入时只校验了声明的扩展名与累计字节数，真正的扩展名、大小与
+	// MIME嗅探检测在合并之后统一进行，与Do共用同一套policy，避免客户端通过
+	// 分块上传绕过u.check原本提供的伪装文件类型检测。
+	if _, err = u.checkFile(ext, stat.Size(), merged); err != nil {
+		os.RemoveAll(u.chunkTmpDir(hash))
+		return false, "", err
+	}
+
+	path = time.Now().Format(u.role) + ext
+	if _, err = u.storage.Put(ctx, path, merged, stat.Size()); err != nil {
+		os.RemoveAll(u.chunkTmpDir(hash))
+		return false, "", err
+	}
+
+	if err = os.RemoveAll(u.chunkTmpDir(hash)); err != nil {
+		return false, "", err
+	}
+
+	if err = ioutil.WriteFile(u.chunkDonePath(hash), []byte(path), defaultFileMode); err != nil {
+		return false, "", err
+	}
+
+	return true, path, nil
+}
+
+// appendChunk 将p指向的分块内容依次写入dst，同时累加到h中用于校验。
+func (u *Upload) appendChunk(dst io.Writer, h io.Writer, p string) error {
+	src, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(io.MultiWriter(dst, h), src)
+	return err
+}
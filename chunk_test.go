@@ -0,0 +1,90 @@
+// Copyright 2015 by caixw, All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func newTestUpload(t *testing.T) *Upload {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "upload-chunk-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	u, err := New(dir, "20060102150405.000000000", Policy{
+		TypeDocument: {
+			MaxSize:    1 << 20,
+			AllowExts:  []string{".txt"},
+			AllowMIMEs: []string{"text/plain; charset=utf-8"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestUpload_mergeChunks_hashMismatch(t *testing.T) {
+	u := newTestUpload(t)
+	ctx := context.Background()
+
+	const hash = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	if err := os.MkdirAll(u.chunkTmpDir(hash), defaultMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(u.chunkPath(hash, 0), []byte("hello world"), defaultFileMode); err != nil {
+		t.Fatal(err)
+	}
+
+	done, _, err := u.mergeChunks(ctx, hash, 1, ".txt")
+	if err == nil {
+		t.Fatal("mergeChunks() error = nil, want hash mismatch error")
+	}
+	if done {
+		t.Error("mergeChunks() done = true, want false on hash mismatch")
+	}
+	if _, err := os.Stat(u.chunkTmpDir(hash)); !os.IsNotExist(err) {
+		t.Error("mergeChunks() should clean up the chunk tmp dir on hash mismatch")
+	}
+}
+
+func TestUpload_chunkCompleted_idempotent(t *testing.T) {
+	u := newTestUpload(t)
+	ctx := context.Background()
+
+	content := []byte("hello world")
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	if err := os.MkdirAll(u.chunkTmpDir(hash), defaultMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(u.chunkPath(hash, 0), content, defaultFileMode); err != nil {
+		t.Fatal(err)
+	}
+
+	done, path, err := u.mergeChunks(ctx, hash, 1, ".txt")
+	if err != nil {
+		t.Fatalf("mergeChunks() error = %v", err)
+	}
+	if !done {
+		t.Fatal("mergeChunks() done = false, want true")
+	}
+
+	// 重复调用chunkCompleted应返回同一路径，不应再次触发合并。
+	got, ok := u.chunkCompleted(ctx, hash)
+	if !ok || got != path {
+		t.Errorf("chunkCompleted() = (%q, %v), want (%q, true)", got, ok, path)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 by caixw, All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LocalStorage 是Storage基于本地磁盘的实现，保持与重构之前一致的行为。
+type LocalStorage struct {
+	dir       string
+	urlPrefix string
+}
+
+// NewLocalStorage 声明一个LocalStorage实例，dir为文件保存的根目录，
+// urlPrefix为访问这些文件所使用的URL前缀。
+func NewLocalStorage(dir, urlPrefix string) *LocalStorage {
+	return &LocalStorage{dir: dir, urlPrefix: urlPrefix}
+}
+
+// Put 实现Storage.Put。
+func (s *LocalStorage) Put(ctx context.Context, relPath string, r io.Reader, size int64) (string, error) {
+	p := s.dir + relPath
+
+	// relPath可能带有子目录，比如派生图片保存在以ImageSpec.Name命名的子目录中。
+	if err := os.MkdirAll(filepath.Dir(p), defaultMode); err != nil {
+		return "", err
+	}
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, defaultFileMode)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = copyContext(ctx, f, r)
+	f.Close()
+	if err != nil {
+		// 写入失败时删除已写入的部分内容，不留下残缺的文件。
+		os.Remove(p)
+		return "", err
+	}
+
+	return s.urlPrefix + relPath, nil
+}
+
+// Exists 实现Storage.Exists。
+func (s *LocalStorage) Exists(ctx context.Context, relPath string) (bool, error) {
+	_, err := os.Stat(s.dir + relPath)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// Delete 实现Storage.Delete。
+func (s *LocalStorage) Delete(ctx context.Context, relPath string) error {
+	return os.Remove(s.dir + relPath)
+}
@@ -0,0 +1,42 @@
+// Copyright 2015 by caixw, All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"context"
+	"io"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+// OSSStorage 是Storage基于阿里云OSS的实现。
+type OSSStorage struct {
+	bucket    *oss.Bucket
+	urlPrefix string
+}
+
+// NewOSSStorage 根据已经初始化好的bucket声明一个OSSStorage实例，
+// urlPrefix为该bucket对外提供访问的域名前缀，比如 https://bucket.oss-cn-hangzhou.aliyuncs.com/。
+func NewOSSStorage(bucket *oss.Bucket, urlPrefix string) *OSSStorage {
+	return &OSSStorage{bucket: bucket, urlPrefix: urlPrefix}
+}
+
+// Put 实现Storage.Put。
+func (s *OSSStorage) Put(ctx context.Context, relPath string, r io.Reader, size int64) (string, error) {
+	if err := s.bucket.PutObject(relPath, r); err != nil {
+		return "", err
+	}
+	return s.urlPrefix + relPath, nil
+}
+
+// Exists 实现Storage.Exists。
+func (s *OSSStorage) Exists(ctx context.Context, relPath string) (bool, error) {
+	return s.bucket.IsObjectExist(relPath)
+}
+
+// Delete 实现Storage.Delete。
+func (s *OSSStorage) Delete(ctx context.Context, relPath string) error {
+	return s.bucket.DeleteObject(relPath)
+}
@@ -0,0 +1,114 @@
+// Copyright 2015 by caixw, All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"path/filepath"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// ResizeMode 控制生成派生图片时的缩放方式。
+type ResizeMode int
+
+// 预定义的缩放方式。
+const (
+	// Fit 保持宽高比缩放，使图片完整地包含在Width、Height指定的区域内。
+	Fit ResizeMode = iota
+
+	// Fill 保持宽高比缩放并居中裁剪，使图片填满Width、Height指定的区域。
+	Fill
+
+	// Thumbnail 生成固定为Width、Height尺寸的缩略图。
+	Thumbnail
+)
+
+// ImageSpec 描述一种图片派生规格。
+type ImageSpec struct {
+	Name   string // 规格名称，同时也是派生图片保存的子目录名
+	Width  int
+	Height int
+	Mode   ResizeMode
+
+	// Quality 为JPEG编码的质量，取值范围[1,100]，仅在派生图片按JPEG编码时有效，
+	// 0表示使用默认质量。
+	Quality int
+}
+
+// SetImagePipeline 设置图片上传成功之后，需要额外生成的派生规格，
+// 比如缩略图、各种尺寸的预览图。
+func (u *Upload) SetImagePipeline(specs []ImageSpec) {
+	u.imageSpecs = specs
+}
+
+// generateDerivatives 按u.imageSpecs为ext格式的图片内容data生成各规格的派生图片，
+// 并将其写入u.storage，返回规格名称到派生图片相对路径的映射。
+// 若未设置图片处理流程，或ext不是受支持的图片格式，返回nil。
+//
+// 即使中途某一规格处理失败，derivatives中也会带上在失败之前已经成功写入
+// u.storage的那些规格，以便调用方在回滚时能够将它们一并清理，不会有遗漏。
+func (u *Upload) generateDerivatives(ctx context.Context, ext string, data []byte) (map[string]string, error) {
+	if len(u.imageSpecs) == 0 || !imageExts[ext] {
+		return nil, nil
+	}
+
+	src, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	derivatives := make(map[string]string, len(u.imageSpecs))
+	for _, spec := range u.imageSpecs {
+		buf := new(bytes.Buffer)
+		if err = encodeImage(buf, resizeImage(src, spec), ext, spec.Quality); err != nil {
+			return derivatives, err
+		}
+
+		relPath := filepath.Join(spec.Name, time.Now().Format(u.role)+ext)
+		if _, err = u.storage.Put(ctx, relPath, buf, int64(buf.Len())); err != nil {
+			return derivatives, err
+		}
+		derivatives[spec.Name] = relPath
+	}
+
+	return derivatives, nil
+}
+
+// resizeImage 根据spec.Mode对src进行缩放。
+func resizeImage(src image.Image, spec ImageSpec) image.Image {
+	switch spec.Mode {
+	case Fill:
+		return imaging.Fill(src, spec.Width, spec.Height, imaging.Center, imaging.Lanczos)
+	case Thumbnail:
+		return imaging.Thumbnail(src, spec.Width, spec.Height, imaging.Lanczos)
+	default: // Fit
+		return imaging.Fit(src, spec.Width, spec.Height, imaging.Lanczos)
+	}
+}
+
+// encodeImage 将img按ext对应的格式编码写入buf。
+func encodeImage(buf *bytes.Buffer, img image.Image, ext string, quality int) error {
+	format := imagingFormat(ext)
+	if format == imaging.JPEG && quality > 0 {
+		return imaging.Encode(buf, img, format, imaging.JPEGQuality(quality))
+	}
+	return imaging.Encode(buf, img, format)
+}
+
+// imagingFormat 将上传时的扩展名转换为imaging包所需的编码格式，默认按JPEG处理。
+func imagingFormat(ext string) imaging.Format {
+	switch ext {
+	case ".png":
+		return imaging.PNG
+	case ".gif":
+		return imaging.GIF
+	default:
+		return imaging.JPEG
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2015 by caixw, All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// failAfterStorage 是一个只允许成功写入前n次的Storage，用于模拟
+// generateDerivatives在处理某一规格时失败的场景。
+type failAfterStorage struct {
+	n   int
+	put int
+}
+
+func (s *failAfterStorage) Put(ctx context.Context, relPath string, r io.Reader, size int64) (string, error) {
+	s.put++
+	if s.put > s.n {
+		return "", errors.New("storage put failed")
+	}
+	return relPath, nil
+}
+
+func (s *failAfterStorage) Exists(ctx context.Context, relPath string) (bool, error) {
+	return false, nil
+}
+
+func (s *failAfterStorage) Delete(ctx context.Context, relPath string) error {
+	return nil
+}
+
+func TestUpload_generateDerivatives_partialFailure(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw := color.RGBA{R: 255, A: 255}
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			img.Set(x, y, draw)
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Upload{
+		role:    "20060102150405.000000000",
+		storage: &failAfterStorage{n: 1},
+	}
+	u.SetImagePipeline([]ImageSpec{
+		{Name: "thumb", Width: 4, Height: 4, Mode: Thumbnail},
+		{Name: "large", Width: 8, Height: 8, Mode: Fit},
+	})
+
+	derivatives, err := u.generateDerivatives(context.Background(), ".png", buf.Bytes())
+	if err == nil {
+		t.Fatal("generateDerivatives() error = nil, want storage put failure")
+	}
+	if _, ok := derivatives["thumb"]; !ok {
+		t.Errorf("generateDerivatives() = %v, want the already-written \"thumb\" entry to survive for rollback", derivatives)
+	}
+	if _, ok := derivatives["large"]; ok {
+		t.Errorf("generateDerivatives() = %v, want no entry for the failed \"large\" spec", derivatives)
+	}
+}
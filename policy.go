@@ -0,0 +1,26 @@
+// Copyright 2015 by caixw, All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package upload
+
+// FileType 用于对上传的文件按大类进行分类，以便为每一类设置不同的大小与类型限制。
+type FileType int
+
+// 预定义的文件分类。
+const (
+	TypeImage FileType = iota
+	TypeVideo
+	TypeDocument
+	TypeArchive
+)
+
+// TypePolicy 描述某一类文件允许的大小、扩展名以及真实的MIME类型。
+type TypePolicy struct {
+	MaxSize    int64    // 允许的最大文件大小，以byte为单位
+	AllowExts  []string // 允许的扩展名
+	AllowMIMEs []string // 通过http.DetectContentType嗅探后允许的MIME类型
+}
+
+// Policy 是FileType到其TypePolicy的映射，描述一次上传整体允许接受的文件类型。
+type Policy map[FileType]TypePolicy
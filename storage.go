@@ -0,0 +1,53 @@
+// Copyright 2015 by caixw, All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"context"
+	"io"
+)
+
+// Storage 用于抽象文件的实际存储方式，使Upload可以在本地磁盘与对象存储服务之间切换，
+// 而不影响上层的校验、水印等逻辑。
+type Storage interface {
+	// Put 将r中的内容写入relPath指定的位置，size为内容的长度，
+	// 返回该文件可直接访问的URL。
+	Put(ctx context.Context, relPath string, r io.Reader, size int64) (url string, err error)
+
+	// Exists 判断relPath指定的文件是否已经存在。
+	Exists(ctx context.Context, relPath string) (bool, error)
+
+	// Delete 删除relPath指定的文件。
+	Delete(ctx context.Context, relPath string) error
+}
+
+// UploadedFile 表示一次成功上传的文件信息。
+type UploadedFile struct {
+	Path string // 相对于u.dir的文件路径
+	URL  string // 可直接访问该文件的URL
+
+	// Derivatives 为图片按SetImagePipeline设置的规格生成的派生图片，
+	// 键为ImageSpec.Name，值为对应派生图片相对于u.dir的路径。
+	// 未设置图片处理流程，或当前文件不是图片时，该字段为nil。
+	Derivatives map[string]string
+}
+
+// Option 用于在New中自定义Upload的可选参数。
+type Option func(*Upload)
+
+// WithStorage 指定Upload实际使用的存储后端，默认为基于本地磁盘的LocalStorage。
+func WithStorage(s Storage) Option {
+	return func(u *Upload) {
+		u.storage = s
+	}
+}
+
+// WithURLPrefix 指定默认的LocalStorage返回URL时使用的前缀，比如域名加静态资源路径。
+// 仅在未通过WithStorage指定其它存储后端时生效。
+func WithURLPrefix(prefix string) Option {
+	return func(u *Upload) {
+		u.urlPrefix = prefix
+	}
+}
@@ -5,6 +5,8 @@
 package upload
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"io"
 	"mime/multipart"
@@ -14,31 +16,47 @@ import (
 	"time"
 )
 
-// 创建文件的默认权限，比如Upload.dir若不存在，会使用此权限创建目录。
-const defaultMode os.FileMode = 0660
+// 创建目录的默认权限，比如Upload.dir若不存在，会使用此权限创建目录。
+// 必须带上执行权限，否则目录本身无法被遍历。
+const defaultMode os.FileMode = 0750
+
+// 创建文件的默认权限，比如保存上传的文件、分块临时文件时使用。
+const defaultFileMode os.FileMode = 0640
 
 // Upload用于处理文件上传
 type Upload struct {
-	dir     string   // 上传文件保存的路径根目录
-	maxSize int64    // 允许的最大文件大小，以byte为单位
-	role    string   // 文件命名方式
-	exts    []string // 允许的扩展名
+	dir    string // 上传文件保存的路径根目录
+	role   string // 文件命名方式
+	policy Policy // 按FileType分类的大小与类型限制
+
+	waterMark       string // 水印文件的路径，或是水印文字的内容
+	waterMarkIsText bool   // waterMark是否为文字内容
+	waterMarkPos    int    // 水印的位置，PosTopLeft至PosBottomRight之一
+
+	imageSpecs []ImageSpec // 图片上传成功后需要额外生成的派生规格
+
+	storage   Storage // 文件的实际存储方式，默认为LocalStorage
+	urlPrefix string  // 默认LocalStorage返回URL时使用的前缀，由WithURLPrefix设置
 }
 
 // 声明一个Upload对象。
-// dir 上传文件的保存目录，若目录不存在，则会尝试创建;
-// maxSize 允许上传文件的最大尺寸，单位为byte；
+// dir 上传文件的保存目录，若目录不存在，则会尝试创建；
 // role 文件命名规则，格式可参考time.Format()参数；
-// exts 允许的扩展名，若为空，将不允许任何文件上传。
-func New(dir string, maxSize int64, role string, exts ...string) (*Upload, error) {
-	// 确保所有的后缀名都是以.作为开始符号的。
-	es := make([]string, 0, len(exts))
-	for _, ext := range exts {
-		if ext[0] != '.' {
-			es = append(es, "."+ext)
-			continue
+// policy 按FileType分类的大小与类型限制，未出现在policy中的扩展名一律不允许上传；
+// opts 用于自定义Upload的可选参数，比如WithStorage。
+func New(dir, role string, policy Policy, opts ...Option) (*Upload, error) {
+	// 确保policy中所有的扩展名都是以.作为开始符号的。
+	normalized := make(Policy, len(policy))
+	for typ, p := range policy {
+		exts := make([]string, 0, len(p.AllowExts))
+		for _, ext := range p.AllowExts {
+			if ext[0] != '.' {
+				ext = "." + ext
+			}
+			exts = append(exts, ext)
 		}
-		es = append(es, ext)
+		p.AllowExts = exts
+		normalized[typ] = p
 	}
 
 	// 确保dir最后一个字符为目录分隔符。
@@ -55,7 +73,7 @@ func New(dir string, maxSize int64, role string, exts ...string) (*Upload, error
 		}
 
 		// 尝试创建目录
-		if err = os.MkdirAll(dir, 0660); err != nil {
+		if err = os.MkdirAll(dir, defaultMode); err != nil {
 			return nil, err
 		}
 
@@ -68,92 +86,183 @@ func New(dir string, maxSize int64, role string, exts ...string) (*Upload, error
 		return nil, errors.New("dir不是一个目录")
 	}
 
-	return &Upload{
-		dir:     dir,
-		maxSize: maxSize,
-		role:    role,
-		exts:    es,
-	}, nil
+	u := &Upload{
+		dir:    dir,
+		role:   role,
+		policy: normalized,
+	}
+
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	// 未通过WithStorage指定其它存储后端时，使用基于本地磁盘的LocalStorage，
+	// 并带上WithURLPrefix设置的前缀。
+	if u.storage == nil {
+		u.storage = NewLocalStorage(dir, u.urlPrefix)
+	}
+
+	return u, nil
 }
 
-// 判断扩展名是否符合要求。
-func (u *Upload) checkExt(ext string) bool {
+// classify 根据ext查找其所属的FileType及对应的TypePolicy。
+// 若policy中没有任何一类允许该扩展名，ok返回false。
+func (u *Upload) classify(ext string) (typ FileType, p TypePolicy, ok bool) {
 	if len(ext) == 0 { // 没有扩展名，一律过滤
-		return false
+		return 0, TypePolicy{}, false
 	}
 
-	// 是否为允许的扩展名
-	for _, e := range u.exts {
-		if e == ext {
-			return true
+	for typ, p := range u.policy {
+		for _, e := range p.AllowExts {
+			if e == ext {
+				return typ, p, true
+			}
 		}
 	}
-	return false
+	return 0, TypePolicy{}, false
 }
 
-// 检测文件大小是否符合要求。
-func (u *Upload) checkSize(file multipart.File) (bool, error) {
-	var size int64
+// sniffMIME 读取r开头的512个字节用于检测真实的MIME类型，检测完成之后会将
+// r的读取位置重置到起始处，以便后续的读取不受影响。
+func sniffMIME(r io.ReadSeeker) (string, error) {
+	buf := make([]byte, 512)
+	n, err := r.Read(buf)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
 
-	switch f := file.(type) {
-	case stater:
-		stat, err := f.Stat()
-		if err != nil {
-			return false, err
-		}
-		size = stat.Size()
-	case sizer:
-		size = f.Size()
-	default:
-		return false, errors.New("上传文件时发生未知的错误")
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return "", err
 	}
 
-	return size <= u.maxSize, nil
+	return http.DetectContentType(buf[:n]), nil
 }
 
-// 设置水印，file为水印文件的路径，或是在isText为true时，file为水印的文字。
-func (u *Upload) SetWaterMark(file string, isText bool) {
-	// TODO
+// check 根据u.policy检测head、file所表示的文件是否符合要求，检测通过后返回
+// 该文件所属的FileType。
+func (u *Upload) check(head *multipart.FileHeader, file multipart.File) (FileType, error) {
+	return u.checkFile(filepath.Ext(head.Filename), head.Size, file)
 }
 
-// 招行上传的操作。会检测上传文件是否符合要求，只要有一个文件不符合，就会中断上传。
-// 返回的是相对于u.dir目录的文件名列表。
-func (u *Upload) Do(field string, w *http.ResponseWriter, r *http.Request) ([]string, error) {
-	r.ParseMultipartForm(32 << 20)
+// checkFile 根据u.policy检测扩展名为ext、大小为size的内容r是否符合要求，
+// 检测通过后返回该文件所属的FileType。Do、DoChunk分别基于multipart.File与
+// 合并之后的临时文件调用该方法，共用同一套扩展名、大小与MIME嗅探规则。
+func (u *Upload) checkFile(ext string, size int64, r io.ReadSeeker) (FileType, error) {
+	typ, p, ok := u.classify(ext)
+	if !ok {
+		return 0, ErrNotAllowExt
+	}
+
+	if size <= 0 || size > p.MaxSize {
+		return 0, ErrNotAllowSize
+	}
+
+	mime, err := sniffMIME(r)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, m := range p.AllowMIMEs {
+		if m == mime {
+			return typ, nil
+		}
+	}
+	// 嗅探出来的MIME类型与声明的扩展名不符，存在伪装成其它类型文件的可能。
+	return 0, ErrNotAllowExt
+}
+
+// DoContext的作用与Do相同，但允许通过ctx提前取消整个上传过程。
+//
+// 会检测上传的每一个文件是否符合要求，只要有一个文件不符合，就会中断上传，
+// 并将本批次中已经成功写入storage的文件（含派生图片）全部删除，避免留下
+// 与返回的错误不一致的半成品。
+// 返回的是每个文件相对于u.dir目录的路径，以及可直接访问该文件的URL。
+func (u *Upload) DoContext(ctx context.Context, field string, r *http.Request) ([]UploadedFile, error) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, err
+	}
+
 	heads := r.MultipartForm.File[field]
-	ret := make([]string, len(heads))
+	ret := make([]UploadedFile, 0, len(heads))
+	written := make([]string, 0, len(heads))
 
 	for _, head := range heads {
-		file, err := head.Open()
+		uploaded, paths, err := u.doOne(ctx, head)
+		written = append(written, paths...)
 		if err != nil {
+			u.cleanup(written)
 			return nil, err
 		}
+		ret = append(ret, uploaded)
+	}
 
-		ext := filepath.Ext(head.Filename)
-		if !u.checkExt(ext) {
-			return nil, errors.New("包含无效的文件类型")
-		}
+	return ret, nil
+}
 
-		ok, err := u.checkSize(file)
-		if err != nil {
-			return nil, err
-		}
-		if !ok {
-			return nil, errors.New("超过最大的文件大小")
-		}
+// doOne处理heads中的单个文件，返回其UploadedFile，以及本次写入storage的所有
+// 相对路径（含失败前已写入的派生图片），供调用方在失败时清理。
+//
+// 只有需要加水印或生成派生图片的图片文件，才会将内容完整解码到内存中；其余
+// 情况下（非图片、或图片但未配置水印与派生规格）直接将上传内容流式写入
+// storage，避免大文件在上传时占用过多内存。
+func (u *Upload) doOne(ctx context.Context, head *multipart.FileHeader) (UploadedFile, []string, error) {
+	file, err := head.Open()
+	if err != nil {
+		return UploadedFile{}, nil, err
+	}
+	defer file.Close()
+
+	if _, err = u.check(head, file); err != nil {
+		return UploadedFile{}, nil, err
+	}
 
-		path := time.Now().Format(u.role) + ext
-		ret = append(ret, path)
-		f, err := os.Create(u.dir + path)
+	ext := filepath.Ext(head.Filename)
+	path := time.Now().Format(u.role) + ext
+
+	if !imageExts[ext] || (u.waterMark == "" && len(u.imageSpecs) == 0) {
+		url, err := u.storage.Put(ctx, path, file, head.Size)
 		if err != nil {
-			return nil, err
+			return UploadedFile{}, nil, err
 		}
+		return UploadedFile{Path: path, URL: url}, []string{path}, nil
+	}
 
-		io.Copy(f, file)
+	content, err := u.markImage(ext, file)
+	if err != nil {
+		return UploadedFile{}, nil, err
+	}
 
-		f.Close()
-		file.Close() // for的最后关闭file
+	buf := new(bytes.Buffer)
+	if _, err = copyContext(ctx, buf, content); err != nil {
+		return UploadedFile{}, nil, err
 	}
+	data := buf.Bytes()
 
-	return ret, nil
-}
\ No newline at end of file
+	url, err := u.storage.Put(ctx, path, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return UploadedFile{}, nil, err
+	}
+	written := []string{path}
+
+	derivatives, err := u.generateDerivatives(ctx, ext, data)
+	for _, p := range derivatives {
+		written = append(written, p)
+	}
+	if err != nil {
+		return UploadedFile{}, written, err
+	}
+
+	return UploadedFile{Path: path, URL: url, Derivatives: derivatives}, written, nil
+}
+
+// cleanup删除paths中列出的、已经写入u.storage的文件，用于批量上传中途失败时的回滚。
+func (u *Upload) cleanup(paths []string) {
+	for _, p := range paths {
+		u.storage.Delete(context.Background(), p)
+	}
+}
+
+// Do是DoContext(context.Background(), field, r)的简单封装，用于兼容旧的调用方式。
+func (u *Upload) Do(field string, w *http.ResponseWriter, r *http.Request) ([]UploadedFile, error) {
+	return u.DoContext(context.Background(), field, r)
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 by caixw, All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// trackingStorage 记录Put、Delete的调用，用于验证DoContext在中途失败时
+// 是否正确回滚了已经写入的文件。
+type trackingStorage struct {
+	deleted []string
+}
+
+func (s *trackingStorage) Put(ctx context.Context, relPath string, r io.Reader, size int64) (string, error) {
+	return relPath, nil
+}
+
+func (s *trackingStorage) Exists(ctx context.Context, relPath string) (bool, error) {
+	return false, nil
+}
+
+func (s *trackingStorage) Delete(ctx context.Context, relPath string) error {
+	s.deleted = append(s.deleted, relPath)
+	return nil
+}
+
+type namedFile struct {
+	name    string
+	content []byte
+}
+
+// newMultipartRequest按files的顺序构造multipart请求，顺序与r.MultipartForm.File[field]
+// 中解析出的顺序一致，以便测试中可以确定每个文件被doOne处理的先后次序。
+func newMultipartRequest(t *testing.T, field string, files []namedFile) *http.Request {
+	t.Helper()
+
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	for _, f := range files {
+		fw, err := mw.CreateFormFile(field, f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = fw.Write(f.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestUpload_DoContext_rollbackOnFailure(t *testing.T) {
+	storage := &trackingStorage{}
+	u, err := New(t.TempDir()+"/", "20060102150405.000000000", Policy{
+		TypeDocument: {
+			MaxSize:    1 << 20,
+			AllowExts:  []string{".txt"},
+			AllowMIMEs: []string{"text/plain; charset=utf-8"},
+		},
+	}, WithStorage(storage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newMultipartRequest(t, "file", []namedFile{
+		{name: "a.txt", content: []byte("hello world")},
+		{name: "b.exe", content: []byte("MZ")},
+	})
+
+	_, err = u.DoContext(context.Background(), "file", r)
+	if err == nil {
+		t.Fatal("DoContext() error = nil, want ErrNotAllowExt for b.exe")
+	}
+	if len(storage.deleted) != 1 {
+		t.Fatalf("DoContext() rolled back %d files, want exactly the 1 file written before the failure", len(storage.deleted))
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2015 by caixw, All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUpload_checkFile(t *testing.T) {
+	u := newTestUpload(t)
+
+	// 扩展名不在policy之中。
+	if _, err := u.checkFile(".png", 10, bytes.NewReader([]byte("hello"))); err != ErrNotAllowExt {
+		t.Errorf("checkFile() ext rejection error = %v, want ErrNotAllowExt", err)
+	}
+
+	// 大小超出TypePolicy.MaxSize。
+	if _, err := u.checkFile(".txt", 1<<21, bytes.NewReader([]byte("hello"))); err != ErrNotAllowSize {
+		t.Errorf("checkFile() size rejection error = %v, want ErrNotAllowSize", err)
+	}
+
+	// 扩展名为.txt，但内容实为PNG，嗅探出来的MIME类型与声明的扩展名不符。
+	png := []byte("\x89PNG\r\n\x1a\n0000000000")
+	if _, err := u.checkFile(".txt", int64(len(png)), bytes.NewReader(png)); err != ErrNotAllowExt {
+		t.Errorf("checkFile() MIME mismatch error = %v, want ErrNotAllowExt", err)
+	}
+
+	// 扩展名、大小、MIME类型均符合policy。
+	text := []byte("hello world")
+	typ, err := u.checkFile(".txt", int64(len(text)), bytes.NewReader(text))
+	if err != nil {
+		t.Fatalf("checkFile() error = %v, want nil", err)
+	}
+	if typ != TypeDocument {
+		t.Errorf("checkFile() type = %v, want TypeDocument", typ)
+	}
+}
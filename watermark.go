@@ -0,0 +1,158 @@
+// Copyright 2015 by caixw, All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// 水印的位置，以图片的九宫格作为参照物。
+const (
+	PosTopLeft = iota
+	PosTopCenter
+	PosTopRight
+	PosMiddleLeft
+	PosMiddleCenter
+	PosMiddleRight
+	PosBottomLeft
+	PosBottomCenter
+	PosBottomRight
+)
+
+// imageExts 是支持添加水印的图片扩展名，不在此列表中的扩展名将原样通过。
+var imageExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+// 设置水印，file 为水印文件的路径，或是在 isText 为 true 时，file 为水印的文字内容。
+// 未调用 SetWaterMark 或是 file 为空值时，表示不需要添加水印。
+func (u *Upload) SetWaterMark(file string, isText bool) {
+	u.waterMark = file
+	u.waterMarkIsText = isText
+}
+
+// 设置水印的位置，pos 的取值必须是 PosTopLeft 至 PosBottomRight 这九个常量之一，
+// 否则将返回 ErrInvalidPos。
+func (u *Upload) SetWaterMarkPos(pos int) error {
+	if pos < PosTopLeft || pos > PosBottomRight {
+		return ErrInvalidPos
+	}
+
+	u.waterMarkPos = pos
+	return nil
+}
+
+// markImage 给扩展名为 ext 的图片内容 r 加上水印，返回处理之后的内容。
+// 如果未设置水印，或是 ext 不是受支持的图片格式，则原样返回 r。
+func (u *Upload) markImage(ext string, r io.Reader) (io.Reader, error) {
+	if u.waterMark == "" || !imageExts[ext] {
+		return r, nil
+	}
+
+	src, format, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := image.NewRGBA(src.Bounds())
+	draw.Draw(dst, dst.Bounds(), src, image.Point{}, draw.Src)
+
+	if u.waterMarkIsText {
+		u.drawText(dst)
+	} else {
+		if err := u.drawImage(dst); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(buf, dst, nil)
+	case "png":
+		err = png.Encode(buf, dst)
+	case "gif":
+		err = gif.Encode(buf, dst, nil)
+	default:
+		return nil, ErrUnsupportedWatermarkType
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// drawImage 将 u.waterMark 所指向的图片文件叠加绘制到 dst 上。
+func (u *Upload) drawImage(dst *image.RGBA) error {
+	data, err := ioutil.ReadFile(u.waterMark)
+	if err != nil {
+		return err
+	}
+
+	mark, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	pt := u.waterMarkPoint(dst.Bounds(), mark.Bounds())
+	draw.Draw(dst, mark.Bounds().Add(pt), mark, image.Point{}, draw.Over)
+	return nil
+}
+
+// drawText 将 u.waterMark 所表示的文字绘制到 dst 上。
+func (u *Upload) drawText(dst *image.RGBA) {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, u.waterMark).Ceil()
+	height := face.Metrics().Height.Ceil()
+	pt := u.waterMarkPoint(dst.Bounds(), image.Rect(0, 0, width, height))
+
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(image.White),
+		Face: face,
+		Dot:  fixed.P(pt.X, pt.Y+face.Metrics().Ascent.Ceil()),
+	}
+	d.DrawString(u.waterMark)
+}
+
+// waterMarkPoint 根据 u.waterMarkPos，计算出 mark 应该绘制在 bound 中的起始坐标。
+func (u *Upload) waterMarkPoint(bound, mark image.Rectangle) image.Point {
+	var x, y int
+
+	switch u.waterMarkPos % 3 {
+	case 0: // 左
+		x = bound.Min.X
+	case 1: // 中
+		x = bound.Min.X + (bound.Dx()-mark.Dx())/2
+	case 2: // 右
+		x = bound.Max.X - mark.Dx()
+	}
+
+	switch u.waterMarkPos / 3 {
+	case 0: // 上
+		y = bound.Min.Y
+	case 1: // 中
+		y = bound.Min.Y + (bound.Dy()-mark.Dy())/2
+	case 2: // 下
+		y = bound.Max.Y - mark.Dy()
+	}
+
+	return image.Point{X: x, Y: y}
+}
@@ -0,0 +1,38 @@
+// Copyright 2015 by caixw, All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"image"
+	"testing"
+)
+
+func TestUpload_waterMarkPoint(t *testing.T) {
+	u := &Upload{}
+	bound := image.Rect(0, 0, 100, 50)
+	mark := image.Rect(0, 0, 20, 10)
+
+	cases := []struct {
+		pos  int
+		want image.Point
+	}{
+		{PosTopLeft, image.Point{X: 0, Y: 0}},
+		{PosTopCenter, image.Point{X: 40, Y: 0}},
+		{PosTopRight, image.Point{X: 80, Y: 0}},
+		{PosMiddleLeft, image.Point{X: 0, Y: 20}},
+		{PosMiddleCenter, image.Point{X: 40, Y: 20}},
+		{PosMiddleRight, image.Point{X: 80, Y: 20}},
+		{PosBottomLeft, image.Point{X: 0, Y: 40}},
+		{PosBottomCenter, image.Point{X: 40, Y: 40}},
+		{PosBottomRight, image.Point{X: 80, Y: 40}},
+	}
+
+	for _, c := range cases {
+		u.waterMarkPos = c.pos
+		if got := u.waterMarkPoint(bound, mark); got != c.want {
+			t.Errorf("pos=%d: waterMarkPoint() = %v, want %v", c.pos, got, c.want)
+		}
+	}
+}